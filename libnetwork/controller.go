@@ -0,0 +1,73 @@
+package libnetwork
+
+import (
+	"context"
+	"sync"
+)
+
+// Config is the subset of daemon-wide configuration the DNS subsystem needs. The full Controller configuration
+// carries many more fields; only the ones this package reads are declared here.
+type Config struct {
+	Daemon DaemonConfig
+}
+
+// DaemonConfig holds the daemon flags that affect the embedded DNS resolver.
+type DaemonConfig struct {
+	// DisableHostResolvConfWatch disables the background watch of the host's resolv.conf that keeps sandboxes
+	// without explicit --dns/--dns-search/--dns-option configuration in sync with host DNS changes.
+	DisableHostResolvConfWatch bool
+
+	// DNSMetricsEnabled enables Prometheus metrics collection for the embedded DNS resolver.
+	DNSMetricsEnabled bool
+	// DNSQueryLogPath, if set, enables structured per-query logging for the embedded DNS resolver, appending
+	// newline-delimited JSON records to this path.
+	DNSQueryLogPath string
+
+	// StrictEncryptedDNS disables the embedded resolver's fall back to plain Do53 when a configured DoT/DoH
+	// upstream is unreachable. See Resolver.SetStrictEncryptedDNS.
+	StrictEncryptedDNS bool
+}
+
+// Controller is the top-level libnetwork handle shared by every sandbox the daemon manages. Only the state the DNS
+// subsystem needs is declared here.
+type Controller struct {
+	cfg Config
+
+	mu        sync.Mutex
+	sandboxes map[string]*Sandbox
+
+	watchedHostResolvConfCancel context.CancelFunc
+
+	// dnsStats and dnsQueryLog are both nil unless DNSMetricsEnabled/DNSQueryLogPath opted in; every sandbox's
+	// embedded resolver shares these so they report into one Prometheus collector and query log.
+	dnsStats    *Stats
+	dnsQueryLog *QueryLogger
+}
+
+// NewController creates a Controller from cfg and starts its background DNS watches.
+func NewController(cfg Config) *Controller {
+	c := &Controller{
+		cfg:       cfg,
+		sandboxes: make(map[string]*Sandbox),
+	}
+	c.configureDNSObservability()
+	c.watchHostResolvConf()
+	return c
+}
+
+// GetSandbox returns the sandbox registered under id, or nil if none is.
+func (c *Controller) GetSandbox(id string) (*Sandbox, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sandboxes[id], nil
+}
+
+// Stop tears down background work started by the controller, such as the host resolv.conf watch.
+func (c *Controller) Stop() {
+	c.mu.Lock()
+	cancel := c.watchedHostResolvConfCancel
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
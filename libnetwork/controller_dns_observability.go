@@ -0,0 +1,27 @@
+package libnetwork
+
+import (
+	"context"
+	"os"
+
+	"github.com/containerd/containerd/log"
+)
+
+// configureDNSObservability sets up the controller-wide DNS metrics collector and query logger according to the
+// daemon configuration, so every sandbox's embedded resolver reports into the same Prometheus collector and
+// query log. It's a no-op (leaving both disabled) unless the daemon opted in, since collecting per-query metrics
+// and logging every query has a real cost that most installs don't need.
+func (c *Controller) configureDNSObservability() {
+	if c.cfg.Daemon.DNSMetricsEnabled {
+		c.dnsStats = NewStats()
+	}
+
+	if path := c.cfg.Daemon.DNSQueryLogPath; path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.G(context.TODO()).WithError(err).WithField("path", path).Warn("libnetwork: not enabling DNS query logging")
+			return
+		}
+		c.dnsQueryLog = NewQueryLogger(f)
+	}
+}
@@ -0,0 +1,81 @@
+package libnetwork
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConfigureDNSObservabilityDisabledByDefault(t *testing.T) {
+	c := NewController(Config{})
+	defer c.Stop()
+
+	if c.dnsStats != nil {
+		t.Error("dnsStats should be nil when DNSMetricsEnabled is false")
+	}
+	if c.dnsQueryLog != nil {
+		t.Error("dnsQueryLog should be nil when DNSQueryLogPath is empty")
+	}
+}
+
+func TestConfigureDNSObservabilityEnablesMetrics(t *testing.T) {
+	c := NewController(Config{Daemon: DaemonConfig{DNSMetricsEnabled: true}})
+	defer c.Stop()
+
+	if c.dnsStats == nil {
+		t.Fatal("dnsStats should be set when DNSMetricsEnabled is true")
+	}
+
+	// Describe/Collect shouldn't block or panic once metrics collection is enabled.
+	descCh := make(chan *prometheus.Desc, 16)
+	go func() {
+		c.dnsStats.Describe(descCh)
+		close(descCh)
+	}()
+	var descCount int
+	for range descCh {
+		descCount++
+	}
+	if descCount == 0 {
+		t.Error("Describe sent no descriptors")
+	}
+}
+
+func TestConfigureDNSObservabilityEnablesQueryLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	c := NewController(Config{Daemon: DaemonConfig{DNSQueryLogPath: path}})
+	defer c.Stop()
+
+	if c.dnsQueryLog == nil {
+		t.Fatal("dnsQueryLog should be set when DNSQueryLogPath is non-empty")
+	}
+
+	c.dnsQueryLog.Log(QueryLogEntry{Name: "example.com.", Type: "A", Source: "internal"})
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var entry QueryLogEntry
+	if err := json.Unmarshal(bytes.TrimSpace(content), &entry); err != nil {
+		t.Fatalf("Unmarshal query log entry: %v", err)
+	}
+	if entry.Name != "example.com." || entry.Source != "internal" {
+		t.Errorf("logged entry = %+v, want Name=example.com. Source=internal", entry)
+	}
+}
+
+func TestConfigureDNSObservabilityBadQueryLogPath(t *testing.T) {
+	// A path under a nonexistent directory can't be opened; configureDNSObservability should warn and leave
+	// dnsQueryLog nil rather than failing NewController outright.
+	c := NewController(Config{Daemon: DaemonConfig{DNSQueryLogPath: filepath.Join(t.TempDir(), "missing", "queries.log")}})
+	defer c.Stop()
+
+	if c.dnsQueryLog != nil {
+		t.Error("dnsQueryLog should stay nil when the log path can't be opened")
+	}
+}
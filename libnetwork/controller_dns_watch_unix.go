@@ -0,0 +1,66 @@
+//go:build !windows
+
+package libnetwork
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/log"
+	"github.com/docker/docker/libnetwork/resolvconf"
+)
+
+// watchHostResolvConf starts watching the host's resolv.conf and, on every change, reconciles every sandbox that
+// doesn't have explicit --dns/--dns-search/--dns-option configuration against the new content. It's a no-op when
+// the controller was configured with DaemonConfig.DisableHostResolvConfWatch.
+//
+// The watcher runs for the lifetime of the controller; callers don't need to stop it explicitly since it's backed
+// by the controller's own termination (c.watchedHostResolvConfCancel is called from Controller.Stop).
+func (c *Controller) watchHostResolvConf() {
+	if c.cfg.Daemon.DisableHostResolvConfWatch {
+		return
+	}
+
+	path := resolvconf.Path()
+	w, err := resolvconf.NewWatcher(path)
+	if err != nil {
+		log.G(context.TODO()).WithError(err).Warn("libnetwork: not watching host resolv.conf for changes")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	c.watchedHostResolvConfCancel = cancel
+	c.mu.Unlock()
+
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case _, ok := <-w.Events():
+				if !ok {
+					return
+				}
+				c.reconcileSandboxHostDNS(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reconcileSandboxHostDNS re-applies the host's current DNS configuration to every sandbox that wasn't given
+// explicit DNS options, so in-flight containers pick up host DNS changes without needing a restart.
+func (c *Controller) reconcileSandboxHostDNS(ctx context.Context) {
+	c.mu.Lock()
+	sandboxes := make([]*Sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.mu.Unlock()
+
+	for _, sb := range sandboxes {
+		if err := sb.reconcileHostDNS(); err != nil {
+			log.G(ctx).WithError(err).WithField("sandbox", sb.ID()).Warn("libnetwork: failed to reconcile sandbox DNS after host resolv.conf change")
+		}
+	}
+}
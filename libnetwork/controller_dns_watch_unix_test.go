@@ -0,0 +1,34 @@
+//go:build !windows
+
+package libnetwork
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWatchHostResolvConfDisabled(t *testing.T) {
+	c := NewController(Config{Daemon: DaemonConfig{DisableHostResolvConfWatch: true}})
+	defer c.Stop()
+
+	c.mu.Lock()
+	cancel := c.watchedHostResolvConfCancel
+	c.mu.Unlock()
+	if cancel != nil {
+		t.Error("watchedHostResolvConfCancel should stay nil when the host resolv.conf watch is disabled")
+	}
+}
+
+func TestReconcileSandboxHostDNSNoSandboxes(t *testing.T) {
+	c := NewController(Config{Daemon: DaemonConfig{DisableHostResolvConfWatch: true}})
+	defer c.Stop()
+
+	// Reconciling with no registered sandboxes should just be a no-op, not panic.
+	c.reconcileSandboxHostDNS(context.Background())
+}
+
+func TestStopWithoutWatchIsSafe(t *testing.T) {
+	c := NewController(Config{Daemon: DaemonConfig{DisableHostResolvConfWatch: true}})
+	// Stop should tolerate watchedHostResolvConfCancel being nil.
+	c.Stop()
+}
@@ -0,0 +1,65 @@
+package libnetwork
+
+import (
+	"net/netip"
+
+	"github.com/docker/docker/libnetwork/resolvconf"
+)
+
+// Endpoint carries the subset of per-endpoint state the DNS subsystem needs: operator-supplied DNS overrides
+// scoped to this one attachment of a container to a network, which take priority over that network's own
+// overrides.
+type Endpoint struct {
+	dnsServers []netip.Addr
+	dnsSearch  []string
+	dnsOptions []resolvconf.Option
+
+	network *Network
+}
+
+// DNSServers returns the endpoint's nameserver overrides.
+func (ep *Endpoint) DNSServers() []netip.Addr {
+	return append([]netip.Addr(nil), ep.dnsServers...)
+}
+
+// SetDNSServers replaces the endpoint's nameserver overrides.
+func (ep *Endpoint) SetDNSServers(servers []netip.Addr) {
+	ep.dnsServers = append([]netip.Addr(nil), servers...)
+}
+
+// DNSSearch returns the endpoint's search domain overrides.
+func (ep *Endpoint) DNSSearch() []string {
+	return append([]string(nil), ep.dnsSearch...)
+}
+
+// SetDNSSearch replaces the endpoint's search domain overrides.
+func (ep *Endpoint) SetDNSSearch(domains []string) {
+	ep.dnsSearch = append([]string(nil), domains...)
+}
+
+// DNSOptions returns the endpoint's resolv.conf option overrides.
+func (ep *Endpoint) DNSOptions() []resolvconf.Option {
+	return append([]resolvconf.Option(nil), ep.dnsOptions...)
+}
+
+// SetDNSOptions replaces the endpoint's resolv.conf option overrides.
+func (ep *Endpoint) SetDNSOptions(options []resolvconf.Option) {
+	ep.dnsOptions = append([]resolvconf.Option(nil), options...)
+}
+
+// getNetwork returns the network ep is attached to.
+func (ep *Endpoint) getNetwork() *Network {
+	return ep.network
+}
+
+// resolvConf renders ep's DNS overrides as a resolvconf.Merge layer. It's safe to call on a nil *Endpoint.
+func (ep *Endpoint) resolvConf() *resolvconf.ResolvConf {
+	if ep == nil {
+		return nil
+	}
+	rc := &resolvconf.ResolvConf{}
+	rc.AddNameservers(ep.dnsServers)
+	rc.SetSearchDomains(ep.dnsSearch)
+	rc.SetOptions(ep.dnsOptions)
+	return rc
+}
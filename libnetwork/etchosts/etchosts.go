@@ -0,0 +1,162 @@
+// Package etchosts provides utility functions to build and update a container's /etc/hosts file.
+package etchosts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/libnetwork/internal/atomicfile"
+)
+
+const (
+	defaultContent = `# modified by Docker
+# (for excessive network congestion avoidance, keep localhost entries in place)
+127.0.0.1	localhost
+::1	localhost ip6-localhost ip6-loopback
+`
+
+	filePerm = 0o644
+)
+
+// Record represents one line to add to (or remove from) /etc/hosts: Hosts is a space-separated list of one or
+// more hostnames that should resolve to IP.
+type Record struct {
+	Hosts string
+	IP    string
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s\t%s", r.IP, r.Hosts)
+}
+
+// fileLocks serializes concurrent Add/Delete/Update calls against the same hosts file, so a read-modify-write
+// cycle from one goroutine can't be interleaved with another's.
+var (
+	fileLocksMu sync.Mutex
+	fileLocks   = map[string]*sync.Mutex{}
+)
+
+func lockFor(path string) *sync.Mutex {
+	fileLocksMu.Lock()
+	defer fileLocksMu.Unlock()
+	l, ok := fileLocks[path]
+	if !ok {
+		l = &sync.Mutex{}
+		fileLocks[path] = l
+	}
+	return l
+}
+
+// Build writes a new /etc/hosts file at path containing the default localhost entries, an entry for hostName (and
+// its FQDN, if domainName is set) pointing at IP (when IP is non-empty), and one line per extraContent record.
+func Build(path, ip, hostName, domainName string, extraContent []Record) error {
+	lock := lockFor(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(defaultContent)
+
+	if ip != "" {
+		name := hostName
+		if domainName != "" {
+			name = fmt.Sprintf("%s.%s %s", hostName, domainName, hostName)
+		}
+		fmt.Fprintf(buf, "%s\t%s\n", ip, name)
+	}
+
+	for _, rec := range extraContent {
+		fmt.Fprintf(buf, "%s\n", rec.String())
+	}
+
+	return atomicfile.WriteFile(path, buf.Bytes(), filePerm)
+}
+
+// Add appends recs to the hosts file at path.
+func Add(path string, recs []Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	lock := lockFor(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(content)
+	for _, rec := range recs {
+		fmt.Fprintf(buf, "%s\n", rec.String())
+	}
+
+	return atomicfile.WriteFile(path, buf.Bytes(), filePerm)
+}
+
+// Delete removes every line matching one of recs from the hosts file at path.
+func Delete(path string, recs []Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	lock := lockFor(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	remove := make(map[string]struct{}, len(recs))
+	for _, rec := range recs {
+		remove[rec.String()] = struct{}{}
+	}
+
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(content), "\n") {
+		if _, ok := remove[line]; ok {
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+
+	return atomicfile.WriteFile(path, out.Bytes(), filePerm)
+}
+
+// Update replaces the IP address on every line of the hosts file at path whose hostnames match name.
+func Update(path, ip, name string) error {
+	lock := lockFor(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, host := range fields[1:] {
+			if host == name {
+				lines[i] = fmt.Sprintf("%s\t%s", ip, strings.Join(fields[1:], " "))
+				break
+			}
+		}
+	}
+
+	return atomicfile.WriteFile(path, []byte(strings.Join(lines, "\n")), filePerm)
+}
@@ -0,0 +1,101 @@
+package etchosts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	extra := []Record{{Hosts: "alias1 alias2", IP: "10.0.0.2"}}
+
+	if err := Build(path, "10.0.0.1", "myhost", "example.com", extra); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	content := readFile(t, path)
+	for _, want := range []string{
+		"127.0.0.1\tlocalhost",
+		"10.0.0.1\tmyhost.example.com myhost",
+		"10.0.0.2\talias1 alias2",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("hosts file missing %q, got:\n%s", want, content)
+		}
+	}
+}
+
+func TestBuildWithoutDomain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := Build(path, "10.0.0.1", "myhost", "", nil); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	content := readFile(t, path)
+	if !strings.Contains(content, "10.0.0.1\tmyhost") {
+		t.Errorf("hosts file missing bare hostname entry, got:\n%s", content)
+	}
+}
+
+func TestAddAndDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := Build(path, "", "myhost", "", nil); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	recs := []Record{{Hosts: "c1", IP: "10.0.0.5"}}
+	if err := Add(path, recs); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if content := readFile(t, path); !strings.Contains(content, "10.0.0.5\tc1") {
+		t.Fatalf("hosts file missing added record, got:\n%s", content)
+	}
+
+	if err := Delete(path, recs); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if content := readFile(t, path); strings.Contains(content, "10.0.0.5\tc1") {
+		t.Fatalf("hosts file still has deleted record, got:\n%s", content)
+	}
+}
+
+func TestAddNoRecordsIsNoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := Build(path, "", "myhost", "", nil); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	before := readFile(t, path)
+
+	if err := Add(path, nil); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if after := readFile(t, path); after != before {
+		t.Fatalf("Add with no records modified the file:\nbefore:\n%s\nafter:\n%s", before, after)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := Build(path, "", "myhost", "", []Record{{Hosts: "c1 c1-alias", IP: "10.0.0.5"}}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if err := Update(path, "10.0.0.6", "c1"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	content := readFile(t, path)
+	if !strings.Contains(content, "10.0.0.6\tc1 c1-alias") {
+		t.Errorf("hosts file entry was not updated, got:\n%s", content)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,57 @@
+package libnetwork
+
+import (
+	"net/netip"
+
+	"github.com/docker/docker/libnetwork/resolvconf"
+)
+
+// Network carries the subset of per-network state the DNS subsystem needs: operator-supplied DNS overrides that
+// apply to every container attached to it (docker network create --dns-server/--dns-search/--dns-option).
+type Network struct {
+	dnsServers []netip.Addr
+	dnsSearch  []string
+	dnsOptions []resolvconf.Option
+}
+
+// DNSServers returns the network's nameserver overrides.
+func (n *Network) DNSServers() []netip.Addr {
+	return append([]netip.Addr(nil), n.dnsServers...)
+}
+
+// SetDNSServers replaces the network's nameserver overrides.
+func (n *Network) SetDNSServers(servers []netip.Addr) {
+	n.dnsServers = append([]netip.Addr(nil), servers...)
+}
+
+// DNSSearch returns the network's search domain overrides.
+func (n *Network) DNSSearch() []string {
+	return append([]string(nil), n.dnsSearch...)
+}
+
+// SetDNSSearch replaces the network's search domain overrides.
+func (n *Network) SetDNSSearch(domains []string) {
+	n.dnsSearch = append([]string(nil), domains...)
+}
+
+// DNSOptions returns the network's resolv.conf option overrides.
+func (n *Network) DNSOptions() []resolvconf.Option {
+	return append([]resolvconf.Option(nil), n.dnsOptions...)
+}
+
+// SetDNSOptions replaces the network's resolv.conf option overrides.
+func (n *Network) SetDNSOptions(options []resolvconf.Option) {
+	n.dnsOptions = append([]resolvconf.Option(nil), options...)
+}
+
+// resolvConf renders n's DNS overrides as a resolvconf.Merge layer. It's safe to call on a nil *Network.
+func (n *Network) resolvConf() *resolvconf.ResolvConf {
+	if n == nil {
+		return nil
+	}
+	rc := &resolvconf.ResolvConf{}
+	rc.AddNameservers(n.dnsServers)
+	rc.SetSearchDomains(n.dnsSearch)
+	rc.SetOptions(n.dnsOptions)
+	return rc
+}
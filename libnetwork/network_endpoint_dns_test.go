@@ -0,0 +1,113 @@
+package libnetwork
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/libnetwork/resolvconf"
+)
+
+func TestNetworkDNSOverrideGettersSetters(t *testing.T) {
+	n := &Network{}
+	servers := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+	n.SetDNSServers(servers)
+	n.SetDNSSearch([]string{"example.com"})
+	n.SetDNSOptions([]resolvconf.Option{"ndots:2"})
+
+	if got := n.DNSServers(); !reflect.DeepEqual(got, servers) {
+		t.Errorf("DNSServers() = %v, want %v", got, servers)
+	}
+	if got := n.DNSSearch(); !reflect.DeepEqual(got, []string{"example.com"}) {
+		t.Errorf("DNSSearch() = %v, want [example.com]", got)
+	}
+	if got := n.DNSOptions(); !reflect.DeepEqual(got, []resolvconf.Option{"ndots:2"}) {
+		t.Errorf("DNSOptions() = %v, want [ndots:2]", got)
+	}
+}
+
+func TestNetworkResolvConfNilSafe(t *testing.T) {
+	var n *Network
+	if rc := n.resolvConf(); rc != nil {
+		t.Errorf("resolvConf() on a nil *Network = %v, want nil", rc)
+	}
+}
+
+func TestNetworkResolvConf(t *testing.T) {
+	n := &Network{}
+	n.SetDNSServers([]netip.Addr{netip.MustParseAddr("10.0.0.1")})
+	n.SetDNSSearch([]string{"example.com"})
+	n.SetDNSOptions([]resolvconf.Option{"ndots:2"})
+
+	rc := n.resolvConf()
+	if got := rc.Nameservers(resolvconf.IP); len(got) != 1 || got[0].String() != "10.0.0.1" {
+		t.Errorf("Nameservers() = %v, want [10.0.0.1]", got)
+	}
+	if got := rc.SearchDomains(); !reflect.DeepEqual(got, []string{"example.com"}) {
+		t.Errorf("SearchDomains() = %v, want [example.com]", got)
+	}
+}
+
+func TestEndpointDNSOverrideGettersSetters(t *testing.T) {
+	ep := &Endpoint{}
+	servers := []netip.Addr{netip.MustParseAddr("10.0.0.2")}
+	ep.SetDNSServers(servers)
+	ep.SetDNSSearch([]string{"corp.example.com"})
+	ep.SetDNSOptions([]resolvconf.Option{"rotate"})
+
+	if got := ep.DNSServers(); !reflect.DeepEqual(got, servers) {
+		t.Errorf("DNSServers() = %v, want %v", got, servers)
+	}
+	if got := ep.DNSSearch(); !reflect.DeepEqual(got, []string{"corp.example.com"}) {
+		t.Errorf("DNSSearch() = %v, want [corp.example.com]", got)
+	}
+	if got := ep.DNSOptions(); !reflect.DeepEqual(got, []resolvconf.Option{"rotate"}) {
+		t.Errorf("DNSOptions() = %v, want [rotate]", got)
+	}
+}
+
+func TestEndpointResolvConfNilSafe(t *testing.T) {
+	var ep *Endpoint
+	if rc := ep.resolvConf(); rc != nil {
+		t.Errorf("resolvConf() on a nil *Endpoint = %v, want nil", rc)
+	}
+}
+
+func TestEndpointGetNetwork(t *testing.T) {
+	n := &Network{}
+	ep := &Endpoint{network: n}
+	if got := ep.getNetwork(); got != n {
+		t.Errorf("getNetwork() = %v, want %v", got, n)
+	}
+}
+
+// TestEndpointNetworkMergePriority exercises the same endpoint-over-network merge order that
+// Sandbox.setupDNS applies: an endpoint-level nameserver override should win over the network's own,
+// while search domains from both layers are concatenated.
+func TestEndpointNetworkMergePriority(t *testing.T) {
+	n := &Network{}
+	n.SetDNSServers([]netip.Addr{netip.MustParseAddr("10.0.0.1")})
+	n.SetDNSSearch([]string{"net.example.com"})
+
+	ep := &Endpoint{network: n}
+	ep.SetDNSServers([]netip.Addr{netip.MustParseAddr("10.0.0.2")})
+	ep.SetDNSSearch([]string{"ep.example.com"})
+
+	merged := resolvconf.Merge(ep.resolvConf(), ep.getNetwork().resolvConf())
+
+	if got := merged.Nameservers(resolvconf.IP); len(got) != 1 || got[0].String() != "10.0.0.2" {
+		t.Errorf("Nameservers() = %v, want the endpoint override [10.0.0.2]", got)
+	}
+	wantSearch := []string{"ep.example.com", "net.example.com"}
+	if got := merged.SearchDomains(); !reflect.DeepEqual(got, wantSearch) {
+		t.Errorf("SearchDomains() = %v, want %v", got, wantSearch)
+	}
+}
+
+func TestEndpointNetworkMergeNoOverrides(t *testing.T) {
+	ep := &Endpoint{network: &Network{}}
+	merged := resolvconf.Merge(ep.resolvConf(), ep.getNetwork().resolvConf())
+	if got := merged.Nameservers(resolvconf.IP); len(got) != 0 {
+		t.Errorf("Nameservers() = %v, want none", got)
+	}
+}
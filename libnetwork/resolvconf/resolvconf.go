@@ -0,0 +1,311 @@
+// Package resolvconf provides utility code to query and update DNS configuration in /etc/resolv.conf files.
+//
+// This package exposes a structured [ResolvConf] builder so that callers no longer need to hand-roll regexp-based
+// parsing and string concatenation every time they need to merge a container's DNS configuration with the host's.
+package resolvconf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/libnetwork/internal/atomicfile"
+)
+
+const (
+	filePerm = 0o644
+
+	alternativeResolverPath = "/run/systemd/resolve/resolv.conf"
+)
+
+// IPFamily identifies a class of nameserver addresses, used to selectively query or remove entries of a given
+// address family.
+type IPFamily int
+
+const (
+	// IP matches both IPv4 and IPv6 nameservers.
+	IP IPFamily = iota
+	// IPv4 matches IPv4 nameservers only.
+	IPv4
+	// IPv6 matches IPv6 nameservers only.
+	IPv6
+)
+
+// Option is a single resolv.conf "options" entry, such as "ndots:0" or "rotate".
+type Option string
+
+// Name returns the option's name, with any ":value" suffix stripped.
+func (o Option) Name() string {
+	name, _, _ := strings.Cut(string(o), ":")
+	return name
+}
+
+// Value returns the option's value and whether it had one (options like "rotate" have no value).
+func (o Option) Value() (string, bool) {
+	_, value, ok := strings.Cut(string(o), ":")
+	return value, ok
+}
+
+// ResolvConf represents the parsed contents of a resolv.conf file, and provides methods to build up a new one.
+type ResolvConf struct {
+	nameservers   []netip.Addr
+	searchDomains []string
+	options       []Option
+}
+
+var (
+	nsRegexp     = regexp.MustCompile(`^\s*nameserver\s*([^\s]+)\s*$`)
+	searchRegexp = regexp.MustCompile(`^\s*search\s*(([^\s]+\s*)*)$`)
+	optRegexp    = regexp.MustCompile(`^\s*options\s*(([^\s]+\s*)*)$`)
+)
+
+// Parse reads the nameserver, search domain and options directives out of the resolv.conf contents in content.
+// Lines that are not recognised (including comments) are ignored.
+func Parse(content []byte) (*ResolvConf, error) {
+	rc := &ResolvConf{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if captures := nsRegexp.FindStringSubmatch(line); captures != nil {
+			addr, err := netip.ParseAddr(captures[1])
+			if err != nil {
+				continue
+			}
+			rc.nameservers = append(rc.nameservers, addr)
+			continue
+		}
+		if captures := searchRegexp.FindStringSubmatch(line); captures != nil {
+			rc.searchDomains = strings.Fields(captures[1])
+			continue
+		}
+		if captures := optRegexp.FindStringSubmatch(line); captures != nil {
+			for _, opt := range strings.Fields(captures[1]) {
+				rc.options = append(rc.options, Option(opt))
+			}
+			continue
+		}
+	}
+	return rc, nil
+}
+
+// Load reads the file at path and parses it, in the same manner as [Parse].
+func Load(path string) (*ResolvConf, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(content)
+}
+
+// Nameservers returns the nameservers of the given family. family == IP returns all of them.
+func (rc *ResolvConf) Nameservers(family IPFamily) []netip.Addr {
+	if family == IP {
+		return append([]netip.Addr(nil), rc.nameservers...)
+	}
+	var out []netip.Addr
+	for _, ns := range rc.nameservers {
+		if (family == IPv4) == ns.Is4() {
+			out = append(out, ns)
+		}
+	}
+	return out
+}
+
+// SearchDomains returns the configured search domains.
+func (rc *ResolvConf) SearchDomains() []string {
+	return append([]string(nil), rc.searchDomains...)
+}
+
+// Options returns the configured options.
+func (rc *ResolvConf) Options() []Option {
+	return append([]Option(nil), rc.options...)
+}
+
+// Option returns the first option whose name matches name, and whether one was found.
+func (rc *ResolvConf) Option(name string) (Option, bool) {
+	for _, opt := range rc.options {
+		if opt.Name() == name {
+			return opt, true
+		}
+	}
+	return "", false
+}
+
+// AddNameservers appends nameservers that are not already present.
+func (rc *ResolvConf) AddNameservers(nameservers []netip.Addr) {
+	for _, ns := range nameservers {
+		var dup bool
+		for _, existing := range rc.nameservers {
+			if existing == ns {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			rc.nameservers = append(rc.nameservers, ns)
+		}
+	}
+}
+
+// RemoveNameserversByType removes every nameserver of the given family (IPv4 or IPv6; IP removes all of them).
+func (rc *ResolvConf) RemoveNameserversByType(family IPFamily) {
+	if family == IP {
+		rc.nameservers = nil
+		return
+	}
+	filtered := rc.nameservers[:0]
+	for _, ns := range rc.nameservers {
+		if (family == IPv4) == ns.Is4() {
+			continue
+		}
+		filtered = append(filtered, ns)
+	}
+	rc.nameservers = filtered
+}
+
+// SetSearchDomains replaces the search domain list.
+func (rc *ResolvConf) SetSearchDomains(domains []string) {
+	rc.searchDomains = append([]string(nil), domains...)
+}
+
+// SetOptions replaces the options list.
+func (rc *ResolvConf) SetOptions(options []Option) {
+	rc.options = append([]Option(nil), options...)
+}
+
+// Merge combines DNS configuration from multiple sources into one ResolvConf. layers are given in priority order,
+// highest priority first (for example: endpoint, sandbox CLI flags, network, host). The first layer that sets any
+// nameservers wins outright, and likewise for options; there's no partial merge between layers for those. Search
+// domains are different: every layer's search domains are concatenated, in the order given, with duplicates
+// removed — this is what lets a container joined to several networks pick up each network's search domain
+// alongside the others instead of just the highest-priority one.
+func Merge(layers ...*ResolvConf) *ResolvConf {
+	out := &ResolvConf{}
+	for _, l := range layers {
+		if l == nil {
+			continue
+		}
+		if len(out.nameservers) == 0 {
+			out.nameservers = append([]netip.Addr(nil), l.nameservers...)
+		}
+		if len(out.options) == 0 {
+			out.options = append([]Option(nil), l.options...)
+		}
+		for _, d := range l.searchDomains {
+			if !contains(out.searchDomains, d) {
+				out.searchDomains = append(out.searchDomains, d)
+			}
+		}
+	}
+	return out
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultIPv4Resolvers is used as a fallback when TransformForLegacyResolver leaves no nameserver at all.
+var defaultIPv4Resolvers = []netip.Addr{
+	netip.MustParseAddr("8.8.8.8"),
+	netip.MustParseAddr("8.8.4.4"),
+}
+
+// TransformForLegacyResolver adapts a host resolv.conf for direct use inside a container network namespace: loopback
+// nameservers (127.0.0.0/8) are meaningless once copied into another namespace, so they're dropped (falling back to
+// the default public resolvers if nothing else is left); when keepIPv6 is false, IPv6 nameservers are dropped too.
+func (rc *ResolvConf) TransformForLegacyResolver(keepIPv6 bool) {
+	filtered := rc.nameservers[:0]
+	for _, ns := range rc.nameservers {
+		if ns.Is4() && ns.As4()[0] == 127 {
+			continue
+		}
+		if !keepIPv6 && !ns.Is4() {
+			continue
+		}
+		filtered = append(filtered, ns)
+	}
+	rc.nameservers = filtered
+	if len(rc.nameservers) == 0 {
+		rc.nameservers = append(rc.nameservers, defaultIPv4Resolvers...)
+	}
+}
+
+// Generate renders the resolv.conf file contents described by rc.
+func (rc *ResolvConf) Generate() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("# Generated by Docker Engine.\n# This file can be edited; Docker Engine will not make further changes once it\n# has been modified.\n\n")
+	for _, ns := range rc.nameservers {
+		fmt.Fprintf(buf, "nameserver %s\n", ns.String())
+	}
+	if len(rc.searchDomains) > 0 {
+		fmt.Fprintf(buf, "search %s\n", strings.Join(rc.searchDomains, " "))
+	}
+	if len(rc.options) > 0 {
+		opts := make([]string, 0, len(rc.options))
+		for _, opt := range rc.options {
+			opts = append(opts, string(opt))
+		}
+		fmt.Fprintf(buf, "options %s\n", strings.Join(opts, " "))
+	}
+	return buf.Bytes()
+}
+
+// Hash returns the digest written alongside resolv.conf so that a later caller can tell whether the file was
+// modified by something other than Write since it was generated.
+func Hash(content []byte) []byte {
+	sum := sha256.Sum256(content)
+	return []byte(fmt.Sprintf("%x", sum))
+}
+
+// Write renders rc and atomically writes it to path. When hash is true, a companion "path.hash" file is written
+// (also atomically) so a later caller can detect whether the file was subsequently edited by the user.
+func (rc *ResolvConf) Write(path string, hash bool) error {
+	content := rc.Generate()
+	if err := atomicfile.WriteFile(path, content, filePerm); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if hash {
+		if err := atomicfile.WriteFile(path+".hash", Hash(content), filePerm); err != nil {
+			return fmt.Errorf("writing %s.hash: %w", path, err)
+		}
+	}
+	return nil
+}
+
+var (
+	detectSystemdResolvConfOnce sync.Once
+	resolvConfPath              = "/etc/resolv.conf"
+)
+
+// Path returns the path to the resolv.conf file that should be treated as the host's configuration. On hosts that
+// run systemd-resolved, /etc/resolv.conf is usually a symlink to a file that only contains the local stub resolver
+// (127.0.0.53); in that case, the systemd-managed file with the real upstream resolvers is used instead.
+func Path() string {
+	detectSystemdResolvConfOnce.Do(func() {
+		content, err := os.ReadFile("/etc/resolv.conf")
+		if err != nil {
+			return
+		}
+		rc, err := Parse(content)
+		if err != nil {
+			return
+		}
+		for _, ns := range rc.Nameservers(IPv4) {
+			if ns.As4() == [4]byte{127, 0, 0, 53} {
+				if _, err := os.Stat(alternativeResolverPath); err == nil {
+					resolvConfPath = alternativeResolverPath
+				}
+				return
+			}
+		}
+	})
+	return resolvConfPath
+}
@@ -0,0 +1,120 @@
+package resolvconf
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := []byte(`
+nameserver 1.1.1.1
+nameserver 2001:4860:4860::8888
+search example.com corp.example.com
+options ndots:1 rotate
+`)
+	rc, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	wantNS := []netip.Addr{netip.MustParseAddr("1.1.1.1"), netip.MustParseAddr("2001:4860:4860::8888")}
+	if got := rc.Nameservers(IP); !reflect.DeepEqual(got, wantNS) {
+		t.Errorf("Nameservers(IP) = %v, want %v", got, wantNS)
+	}
+	if got := rc.Nameservers(IPv4); !reflect.DeepEqual(got, wantNS[:1]) {
+		t.Errorf("Nameservers(IPv4) = %v, want %v", got, wantNS[:1])
+	}
+	if got := rc.Nameservers(IPv6); !reflect.DeepEqual(got, wantNS[1:]) {
+		t.Errorf("Nameservers(IPv6) = %v, want %v", got, wantNS[1:])
+	}
+
+	wantSearch := []string{"example.com", "corp.example.com"}
+	if got := rc.SearchDomains(); !reflect.DeepEqual(got, wantSearch) {
+		t.Errorf("SearchDomains() = %v, want %v", got, wantSearch)
+	}
+
+	if opt, ok := rc.Option("ndots"); !ok {
+		t.Error(`Option("ndots") not found`)
+	} else if value, _ := opt.Value(); value != "1" {
+		t.Errorf(`Option("ndots").Value() = %q, want "1"`, value)
+	}
+	if _, ok := rc.Option("rotate"); !ok {
+		t.Error(`Option("rotate") not found`)
+	}
+}
+
+func TestParseIgnoresUnrecognizedLines(t *testing.T) {
+	content := []byte("# a comment\nnameserver not-an-ip\ndomain example.com\nnameserver 8.8.8.8\n")
+	rc, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []netip.Addr{netip.MustParseAddr("8.8.8.8")}
+	if got := rc.Nameservers(IP); !reflect.DeepEqual(got, want) {
+		t.Errorf("Nameservers(IP) = %v, want %v", got, want)
+	}
+}
+
+func TestMergePriority(t *testing.T) {
+	high := &ResolvConf{}
+	high.AddNameservers([]netip.Addr{netip.MustParseAddr("10.0.0.1")})
+	high.SetSearchDomains([]string{"high.example.com"})
+
+	low := &ResolvConf{}
+	low.AddNameservers([]netip.Addr{netip.MustParseAddr("10.0.0.2")})
+	low.SetSearchDomains([]string{"low.example.com", "high.example.com"})
+	low.SetOptions([]Option{"ndots:0"})
+
+	merged := Merge(high, low)
+
+	wantNS := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+	if got := merged.Nameservers(IP); !reflect.DeepEqual(got, wantNS) {
+		t.Errorf("Nameservers(IP) = %v, want %v (high priority nameservers should win outright)", got, wantNS)
+	}
+
+	wantSearch := []string{"high.example.com", "low.example.com"}
+	if got := merged.SearchDomains(); !reflect.DeepEqual(got, wantSearch) {
+		t.Errorf("SearchDomains() = %v, want %v (search domains should concatenate across layers, deduped)", got, wantSearch)
+	}
+
+	// Options weren't set on the higher-priority layer, so the lower one's should show through.
+	wantOpts := []Option{"ndots:0"}
+	if got := merged.Options(); !reflect.DeepEqual(got, wantOpts) {
+		t.Errorf("Options() = %v, want %v", got, wantOpts)
+	}
+}
+
+func TestMergeSkipsNilLayers(t *testing.T) {
+	only := &ResolvConf{}
+	only.AddNameservers([]netip.Addr{netip.MustParseAddr("9.9.9.9")})
+
+	merged := Merge(nil, only, nil)
+	want := []netip.Addr{netip.MustParseAddr("9.9.9.9")}
+	if got := merged.Nameservers(IP); !reflect.DeepEqual(got, want) {
+		t.Errorf("Nameservers(IP) = %v, want %v", got, want)
+	}
+}
+
+func TestTransformForLegacyResolver(t *testing.T) {
+	rc := &ResolvConf{}
+	rc.AddNameservers([]netip.Addr{
+		netip.MustParseAddr("127.0.0.53"),
+		netip.MustParseAddr("2001:4860:4860::8888"),
+	})
+
+	rc.TransformForLegacyResolver(false)
+	if got := rc.Nameservers(IP); len(got) != 0 {
+		t.Errorf("Nameservers(IP) = %v, want empty (loopback and IPv6 both dropped)", got)
+	}
+}
+
+func TestTransformForLegacyResolverFallsBackWhenOnlyLoopback(t *testing.T) {
+	rc := &ResolvConf{}
+	rc.AddNameservers([]netip.Addr{netip.MustParseAddr("127.0.0.1")})
+
+	rc.TransformForLegacyResolver(true)
+	if got := rc.Nameservers(IP); !reflect.DeepEqual(got, defaultIPv4Resolvers) {
+		t.Errorf("Nameservers(IP) = %v, want default resolvers %v", got, defaultIPv4Resolvers)
+	}
+}
@@ -0,0 +1,81 @@
+package resolvconf
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher notifies callers when the resolv.conf file it was created for changes on disk. It's used to propagate
+// host DNS configuration changes (VPN up/down, DHCP lease renewal, systemd-resolved reload, ...) into sandboxes
+// without requiring a container restart.
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	events  chan struct{}
+	done    chan struct{}
+}
+
+// NewWatcher starts watching path (normally the result of [Path]) for changes and returns a Watcher whose Events
+// channel receives a notification every time the file is created, written or replaced (editors and package
+// managers commonly replace resolv.conf rather than writing it in place, so the parent directory is watched rather
+// than the file itself).
+func NewWatcher(path string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("resolvconf: creating watcher: %w", err)
+	}
+	if err := fsw.Add(filepath.Dir(path)); err != nil {
+		fsw.Close()
+		return nil, fmt.Errorf("resolvconf: watching %s: %w", path, err)
+	}
+
+	w := &Watcher{
+		watcher: fsw,
+		events:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go w.run(path)
+	return w, nil
+}
+
+func (w *Watcher) run(path string) {
+	defer close(w.events)
+	for {
+		select {
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Name != path {
+				continue
+			}
+			if !ev.Op.Has(fsnotify.Write) && !ev.Op.Has(fsnotify.Create) && !ev.Op.Has(fsnotify.Rename) {
+				continue
+			}
+			select {
+			case w.events <- struct{}{}:
+			default:
+				// a notification is already pending; the reader will re-read the current content anyway.
+			}
+		case <-w.watcher.Errors:
+			// Errors surfaced by fsnotify are almost always benign (e.g. a watched file was removed and
+			// re-created by a rename); the caller doesn't have a file descriptor to act on here, so just
+			// keep watching.
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Events returns the channel on which a value is sent after the watched file changes. The channel is closed once
+// Close is called.
+func (w *Watcher) Events() <-chan struct{} {
+	return w.events
+}
+
+// Close stops the watcher and releases its underlying resources.
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.watcher.Close()
+}
@@ -0,0 +1,339 @@
+package libnetwork
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsPort = "53"
+
+	// respTTL is the TTL (in seconds) given to answers synthesized from the sandbox's own service discovery
+	// data (container names, network aliases, etc).
+	respTTL = 600
+
+	extIOTimeout = 4 * time.Second
+)
+
+// extDNSEntry is an upstream DNS server that the embedded resolver forwards queries to when it can't answer them
+// from the sandbox's own service discovery data.
+type extDNSEntry struct {
+	IPStr string
+	// Port is the upstream port to dial, taken from the optional "@<port>" suffix on a --dns value. Empty means
+	// use the transport's default (853 for TransportTLS, 53 otherwise); TransportHTTPS ignores this field since
+	// its port, if any, is already part of the DoH URL in IPStr.
+	Port string
+	// HostLoopback records that IPStr refers to the host's own loopback resolver. Since the sandbox runs in its
+	// own network namespace, such a query must be forwarded through the host namespace rather than dialed
+	// directly.
+	HostLoopback bool
+
+	// Transport is the protocol used to reach this server. The zero value ("") is handled as TransportUDP,
+	// preserving the historical Do53 behavior of falling back to TCP only on truncation.
+	Transport Transport
+	// ServerName is the name to validate the peer certificate against, for TransportTLS and TransportHTTPS.
+	// Required for TransportTLS; for TransportHTTPS it defaults to the host component of the server URL.
+	ServerName string
+	// Bootstrap is the set of addresses used to dial the server when it was configured by hostname (DoH servers
+	// are almost always configured this way), avoiding a chicken-and-egg dependency on DNS resolution itself.
+	Bootstrap []netip.Addr
+}
+
+// Resolver is the embedded DNS server started for every sandbox, answering queries for container names and
+// network aliases out of the sandbox's own service discovery data, and forwarding everything else upstream.
+type Resolver struct {
+	sb       *Sandbox
+	proxyDNS bool
+
+	mu         sync.Mutex
+	extDNSList []extDNSEntry
+	// strictEncryptedDNS disables the fall back to Do53 when a DoT/DoH upstream is configured but unreachable.
+	strictEncryptedDNS bool
+
+	transports upstreamTransports
+
+	server    *dns.Server
+	tcpServer *dns.Server
+
+	listenAddress string
+
+	// stats and queryLog are both nil unless observability has been enabled on the controller; every use goes
+	// through their nil-safe methods so the hot path doesn't need to branch on whether they're configured.
+	stats    *Stats
+	queryLog *QueryLogger
+}
+
+// SetObservability enables or disables metrics collection and query logging for this resolver. Either argument
+// may be nil to leave that aspect disabled.
+func (r *Resolver) SetObservability(stats *Stats, queryLog *QueryLogger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = stats
+	r.queryLog = queryLog
+}
+
+// NewResolver creates an embedded resolver bound to address (normally 127.0.0.11, the well-known address docker
+// injects into every container's resolv.conf). proxyDNS controls whether queries that aren't answered from the
+// sandbox's own data are forwarded upstream at all; it's always true today (see the comment at the call site in
+// startResolver), but is kept as a parameter to match the shape callers expect.
+func NewResolver(address string, proxyDNS bool, sb *Sandbox) *Resolver {
+	return &Resolver{
+		sb:            sb,
+		proxyDNS:      proxyDNS,
+		listenAddress: address,
+		transports:    newUpstreamTransports(),
+	}
+}
+
+// SetExtServers replaces the set of upstream DNS servers queries are forwarded to.
+func (r *Resolver) SetExtServers(extDNS []extDNSEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extDNSList = append([]extDNSEntry(nil), extDNS...)
+}
+
+// SetStrictEncryptedDNS controls whether a DoT/DoH upstream that can't be reached is allowed to fall back to
+// plain Do53. It's surfaced through the controller config as "strict DNS" mode.
+func (r *Resolver) SetStrictEncryptedDNS(strict bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.strictEncryptedDNS = strict
+}
+
+// NameServer returns the address the resolver listens on, for inclusion in the sandbox's resolv.conf.
+func (r *Resolver) NameServer() string {
+	return r.listenAddress
+}
+
+// ResolverOptions returns the resolv.conf "options" entries the embedded resolver needs the container to use, for
+// example to force ndots:0 so unqualified names resolve against the embedded server's search domain first.
+func (r *Resolver) ResolverOptions() []string {
+	return []string{"ndots:0"}
+}
+
+// SetupFunc returns a function to be run inside the sandbox's network namespace (via osSbox.InvokeFunc) that binds
+// the resolver's listening sockets before Start is called.
+func (r *Resolver) SetupFunc(port int) func() error {
+	return func() error {
+		addr := net.JoinHostPort(r.listenAddress, dnsPort)
+		if port != 0 {
+			addr = net.JoinHostPort(r.listenAddress, fmt.Sprintf("%d", port))
+		}
+
+		udpConn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("error in opening name server socket %v", err)
+		}
+		r.server = &dns.Server{PacketConn: udpConn, Handler: dns.HandlerFunc(r.serveDNS)}
+
+		tcpListener, err := net.Listen("tcp", addr)
+		if err != nil {
+			udpConn.Close()
+			return fmt.Errorf("error in opening name TCP server socket %v", err)
+		}
+		r.tcpServer = &dns.Server{Listener: tcpListener, Handler: dns.HandlerFunc(r.serveDNS)}
+
+		return nil
+	}
+}
+
+// Start runs the resolver's UDP and TCP servers in the background.
+func (r *Resolver) Start() error {
+	if r.server == nil || r.tcpServer == nil {
+		return fmt.Errorf("resolver sockets are not configured")
+	}
+	go func() {
+		if err := r.server.ActivateAndServe(); err != nil {
+			log.G(context.TODO()).WithError(err).Error("libnetwork: failed to start embedded DNS UDP server")
+		}
+	}()
+	go func() {
+		if err := r.tcpServer.ActivateAndServe(); err != nil {
+			log.G(context.TODO()).WithError(err).Error("libnetwork: failed to start embedded DNS TCP server")
+		}
+	}()
+	return nil
+}
+
+// Stop shuts down the resolver's listening sockets.
+func (r *Resolver) Stop() {
+	if r.server != nil {
+		r.server.Shutdown() //nolint:errcheck
+	}
+	if r.tcpServer != nil {
+		r.tcpServer.Shutdown() //nolint:errcheck
+	}
+}
+
+// serveDNS answers a query out of the sandbox's own service discovery data, falling back to forwarding it
+// upstream when proxyDNS is enabled and nothing matched locally.
+func (r *Resolver) serveDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if len(query.Question) == 0 {
+		return
+	}
+	q := query.Question[0]
+	start := time.Now()
+
+	proto := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		proto = "tcp"
+	}
+
+	var resp *dns.Msg
+	var source string
+	if resp = r.resolveLocal(query); resp != nil {
+		source = "internal"
+		r.stats.internalHit()
+	} else if r.proxyDNS {
+		var server string
+		var err error
+		resp, server, err = r.forward(query)
+		if err != nil {
+			log.G(context.TODO()).WithError(err).WithField("name", q.Name).Debug("libnetwork: failed to forward DNS query")
+			dns.HandleFailed(w, query)
+			r.stats.queryTotal(proto, dns.RcodeServerFailure)
+			return
+		}
+		source = "upstream:" + server
+	} else {
+		dns.HandleFailed(w, query)
+		r.stats.queryTotal(proto, dns.RcodeServerFailure)
+		return
+	}
+
+	if resp.Truncated {
+		r.stats.truncatedResponse()
+	}
+	r.stats.queryTotal(proto, resp.Rcode)
+	if r.queryLog != nil {
+		r.queryLog.Log(QueryLogEntry{
+			Time:      start,
+			ClientIP:  clientIPFromAddr(w.RemoteAddr()),
+			Name:      q.Name,
+			Type:      dns.TypeToString[q.Qtype],
+			Source:    source,
+			Latency:   time.Since(start),
+			Truncated: resp.Truncated,
+		})
+	}
+
+	w.WriteMsg(resp) //nolint:errcheck
+}
+
+func clientIPFromAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// resolveLocal answers q out of the sandbox's own hosts/service-discovery records, returning nil if nothing
+// matched so the caller can fall back to forwarding the query upstream.
+func (r *Resolver) resolveLocal(query *dns.Msg) *dns.Msg {
+	q := query.Question[0]
+	name := strings.TrimSuffix(q.Name, ".")
+
+	ip := r.sb.resolveName(name, familyForQType(q.Qtype))
+	if ip == nil {
+		return nil
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Authoritative = true
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", q.Name, respTTL, dns.TypeToString[q.Qtype], ip.String()))
+	if err != nil {
+		return nil
+	}
+	resp.Answer = append(resp.Answer, rr)
+	return resp
+}
+
+func familyForQType(qtype uint16) int {
+	if qtype == dns.TypeAAAA {
+		return 6
+	}
+	return 4
+}
+
+// forward sends query to the first reachable upstream server, using each server's configured transport.
+func (r *Resolver) forward(query *dns.Msg) (*dns.Msg, string, error) {
+	r.mu.Lock()
+	servers := append([]extDNSEntry(nil), r.extDNSList...)
+	strict := r.strictEncryptedDNS
+	r.mu.Unlock()
+
+	if len(servers) == 0 {
+		return nil, "", fmt.Errorf("no upstream DNS servers configured")
+	}
+
+	var lastErr error
+	for _, srv := range servers {
+		start := time.Now()
+		resp, err := r.exchange(srv, query)
+		if err == nil {
+			r.stats.observeUpstreamLatency(time.Since(start))
+			return resp, srv.IPStr, nil
+		}
+		lastErr = err
+		r.stats.upstreamError(srv.IPStr)
+
+		if srv.Transport == TransportTLS || srv.Transport == TransportHTTPS {
+			log.G(context.TODO()).WithError(err).WithField("server", srv.IPStr).Warn("libnetwork: encrypted upstream DNS server unreachable")
+			if strict {
+				continue
+			}
+			do53 := srv
+			do53.Transport = TransportUDP
+			do53.Port = ""
+			if srv.Transport == TransportHTTPS {
+				// For DoH, IPStr is the server's URL, not a dialable address; fall back via its bootstrap
+				// address instead.
+				if len(srv.Bootstrap) == 0 {
+					log.G(context.TODO()).WithField("server", srv.IPStr).Warn("libnetwork: no bootstrap address available to fall back to Do53")
+					continue
+				}
+				do53.IPStr = srv.Bootstrap[0].String()
+			}
+			start := time.Now()
+			if resp, err := r.exchange(do53, query); err == nil {
+				r.stats.observeUpstreamLatency(time.Since(start))
+				return resp, do53.IPStr, nil
+			}
+		}
+	}
+	return nil, "", lastErr
+}
+
+// exchange sends query to a single upstream server over its configured transport.
+func (r *Resolver) exchange(srv extDNSEntry, query *dns.Msg) (*dns.Msg, error) {
+	switch srv.Transport {
+	case TransportTLS:
+		return r.transports.exchangeTLS(srv, query)
+	case TransportHTTPS:
+		return r.transports.exchangeHTTPS(srv, query)
+	case TransportTCP:
+		client := &dns.Client{Net: "tcp", Timeout: extIOTimeout}
+		resp, _, err := client.Exchange(query, net.JoinHostPort(srv.IPStr, effectivePort(srv, dnsPort)))
+		return resp, err
+	default:
+		addr := net.JoinHostPort(srv.IPStr, effectivePort(srv, dnsPort))
+		client := &dns.Client{Timeout: extIOTimeout}
+		resp, _, err := client.Exchange(query, addr)
+		if err == nil && resp.Truncated {
+			tcpClient := &dns.Client{Net: "tcp", Timeout: extIOTimeout}
+			resp, _, err = tcpClient.Exchange(query, addr)
+		}
+		return resp, err
+	}
+}
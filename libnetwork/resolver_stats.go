@@ -0,0 +1,160 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stats collects metrics about the embedded DNS resolver's query handling: how many queries came in and with
+// what result, how slow upstream lookups were, which upstream servers are failing, and how often a query was
+// answered locally instead of being forwarded. A nil *Stats is a no-op, so a resolver that hasn't had metrics
+// enabled doesn't need to nil-check before every update.
+type Stats struct {
+	queriesTotal    *prometheus.CounterVec
+	upstreamLatency prometheus.Histogram
+	upstreamErrors  *prometheus.CounterVec
+	internalHits    prometheus.Counter
+	truncated       prometheus.Counter
+}
+
+// NewStats creates a resolver Stats collector. Register it with a prometheus.Registerer to expose it; it also
+// implements prometheus.Collector directly so it can be registered as-is.
+func NewStats() *Stats {
+	return &Stats{
+		queriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "libnetwork",
+			Subsystem: "dns",
+			Name:      "queries_total",
+			Help:      "Count of DNS queries handled by the embedded resolver, by transport protocol and response code.",
+		}, []string{"proto", "rcode"}),
+		upstreamLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "libnetwork",
+			Subsystem: "dns",
+			Name:      "upstream_latency_seconds",
+			Help:      "Latency of queries forwarded to upstream DNS servers.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "libnetwork",
+			Subsystem: "dns",
+			Name:      "upstream_errors_total",
+			Help:      "Count of failed upstream DNS queries, by upstream server.",
+		}, []string{"server"}),
+		internalHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "libnetwork",
+			Subsystem: "dns",
+			Name:      "internal_hits_total",
+			Help:      "Count of queries answered from the sandbox's own service discovery data, without forwarding upstream.",
+		}),
+		truncated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "libnetwork",
+			Subsystem: "dns",
+			Name:      "truncated_total",
+			Help:      "Count of responses returned with the TC (truncated) bit set.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (s *Stats) Describe(ch chan<- *prometheus.Desc) {
+	if s == nil {
+		return
+	}
+	s.queriesTotal.Describe(ch)
+	s.upstreamLatency.Describe(ch)
+	s.upstreamErrors.Describe(ch)
+	s.internalHits.Describe(ch)
+	s.truncated.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *Stats) Collect(ch chan<- prometheus.Metric) {
+	if s == nil {
+		return
+	}
+	s.queriesTotal.Collect(ch)
+	s.upstreamLatency.Collect(ch)
+	s.upstreamErrors.Collect(ch)
+	s.internalHits.Collect(ch)
+	s.truncated.Collect(ch)
+}
+
+func (s *Stats) queryTotal(proto string, rcode int) {
+	if s == nil {
+		return
+	}
+	s.queriesTotal.WithLabelValues(proto, dns.RcodeToString[rcode]).Inc()
+}
+
+func (s *Stats) observeUpstreamLatency(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.upstreamLatency.Observe(d.Seconds())
+}
+
+func (s *Stats) upstreamError(server string) {
+	if s == nil {
+		return
+	}
+	s.upstreamErrors.WithLabelValues(server).Inc()
+}
+
+func (s *Stats) internalHit() {
+	if s == nil {
+		return
+	}
+	s.internalHits.Inc()
+}
+
+func (s *Stats) truncatedResponse() {
+	if s == nil {
+		return
+	}
+	s.truncated.Inc()
+}
+
+// QueryLogEntry is one structured record describing a single DNS query handled by the embedded resolver.
+type QueryLogEntry struct {
+	Time      time.Time     `json:"time"`
+	ClientIP  string        `json:"client_ip"`
+	Name      string        `json:"qname"`
+	Type      string        `json:"qtype"`
+	Source    string        `json:"source"` // "internal", or "upstream:<server>"
+	Latency   time.Duration `json:"latency_ns"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+// QueryLogger writes one JSON record per DNS query to an io.Writer, for operators debugging "DNS is slow in my
+// container" reports. A nil *QueryLogger is a no-op.
+type QueryLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewQueryLogger creates a QueryLogger that appends newline-delimited JSON records to w.
+func NewQueryLogger(w io.Writer) *QueryLogger {
+	return &QueryLogger{w: w}
+}
+
+// Log writes entry to the query log, silently dropping it on a marshal or write error since query logging must
+// never be allowed to affect DNS resolution itself.
+func (l *QueryLogger) Log(entry QueryLogEntry) {
+	if l == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(data) //nolint:errcheck
+}
@@ -0,0 +1,345 @@
+package libnetwork
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Transport identifies the protocol used to reach an upstream DNS server.
+type Transport string
+
+const (
+	// TransportUDP is plain Do53 over UDP, falling back to TCP on truncation. This is the zero value.
+	TransportUDP Transport = "udp"
+	// TransportTCP is plain Do53 over TCP.
+	TransportTCP Transport = "tcp"
+	// TransportTLS is DNS-over-TLS (RFC 7858).
+	TransportTLS Transport = "tls"
+	// TransportHTTPS is DNS-over-HTTPS (RFC 8484).
+	TransportHTTPS Transport = "https"
+)
+
+// dnsOverTLSPort is the default port for TransportTLS upstreams, used when no "@<port>" was given.
+const dnsOverTLSPort = "853"
+
+// effectivePort returns the port to dial srv on: its explicit "@<port>" if one was given, otherwise def.
+func effectivePort(srv extDNSEntry, def string) string {
+	if srv.Port != "" {
+		return srv.Port
+	}
+	return def
+}
+
+// ParseUpstream parses a --dns value that may name an encrypted upstream resolver, in one of the forms:
+//
+//	<ip>                                         plain Do53, equivalent to udp://<ip>
+//	udp://<ip>[@<port>]
+//	tcp://<ip>[@<port>]
+//	tls://<ip>[@<port>]#<server-name>            DNS-over-TLS; server-name is required
+//	https://<host>[:<port>]/<path>               DNS-over-HTTPS
+//
+// bootstrap supplies the addresses used to dial a server that's named by hostname (typically a DoH server),
+// sidestepping the chicken-and-egg problem of needing DNS to resolve the DNS server.
+func ParseUpstream(value string, bootstrap []netip.Addr) (extDNSEntry, error) {
+	scheme, rest, hasScheme := strings.Cut(value, "://")
+	if !hasScheme {
+		if _, err := netip.ParseAddr(value); err != nil {
+			return extDNSEntry{}, fmt.Errorf("invalid DNS server %q: %w", value, err)
+		}
+		return extDNSEntry{IPStr: value, Transport: TransportUDP}, nil
+	}
+
+	switch Transport(scheme) {
+	case TransportUDP, TransportTCP, TransportTLS:
+		hostport, serverName, _ := strings.Cut(rest, "#")
+		ipStr, port, hasPort := strings.Cut(hostport, "@")
+		if _, err := netip.ParseAddr(ipStr); err != nil {
+			return extDNSEntry{}, fmt.Errorf("invalid DNS server %q: %w", value, err)
+		}
+		if Transport(scheme) == TransportTLS && serverName == "" {
+			return extDNSEntry{}, fmt.Errorf("tls:// DNS server %q is missing a #server-name", value)
+		}
+		if hasPort {
+			if _, err := strconv.Atoi(port); err != nil {
+				return extDNSEntry{}, fmt.Errorf("invalid port in DNS server %q: %w", value, err)
+			}
+		}
+		return extDNSEntry{
+			IPStr:      ipStr,
+			Port:       port,
+			Transport:  Transport(scheme),
+			ServerName: serverName,
+			Bootstrap:  bootstrap,
+		}, nil
+	case TransportHTTPS:
+		u, err := url.Parse(value)
+		if err != nil {
+			return extDNSEntry{}, fmt.Errorf("invalid DoH DNS server %q: %w", value, err)
+		}
+		return extDNSEntry{
+			IPStr:      u.String(),
+			Transport:  TransportHTTPS,
+			ServerName: u.Hostname(),
+			Bootstrap:  bootstrap,
+		}, nil
+	default:
+		return extDNSEntry{}, fmt.Errorf("unsupported DNS server scheme %q in %q", scheme, value)
+	}
+}
+
+// upstreamTransports holds the pooled connections used to talk to encrypted upstream resolvers, so a TLS
+// handshake or HTTP/2 connection setup isn't paid for on every query.
+type upstreamTransports struct {
+	mu        sync.Mutex
+	tlsConns  map[string]*pooledTLSConn
+	httpConns map[string]*http.Client
+	dohCache  map[string]dohCacheEntry
+}
+
+// dohCacheEntry is a cached DoH response, kept until expires per the original response's Cache-Control: max-age.
+type dohCacheEntry struct {
+	resp    *dns.Msg
+	expires time.Time
+}
+
+// pooledTLSConn is a DNS-over-TLS connection shared by every query to one upstream server. RFC 7858's length-prefixed
+// framing has no way to match a response back to the query that triggered it, and the embedded resolver's DNS
+// server dispatches serveDNS concurrently, so two queries racing on the same connection would otherwise interleave
+// their writes and reads and hand one client the other's answer. mu is held across the full write-then-read
+// exchange to serialize access.
+type pooledTLSConn struct {
+	mu   sync.Mutex
+	conn *tls.Conn
+}
+
+func newUpstreamTransports() upstreamTransports {
+	return upstreamTransports{
+		tlsConns:  make(map[string]*pooledTLSConn),
+		httpConns: make(map[string]*http.Client),
+		dohCache:  make(map[string]dohCacheEntry),
+	}
+}
+
+// exchangeTLS performs a DNS-over-TLS exchange (RFC 7858): the message is framed with a 2-byte length prefix over
+// a TLS connection validated against srv.ServerName, reusing a pooled connection where possible.
+func (t *upstreamTransports) exchangeTLS(srv extDNSEntry, query *dns.Msg) (*dns.Msg, error) {
+	pc, err := t.tlsConn(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	pc.conn.SetDeadline(time.Now().Add(extIOTimeout)) //nolint:errcheck
+
+	var lenBuf [2]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(len(packed)))
+	if _, err := pc.conn.Write(append(lenBuf[:], packed...)); err != nil {
+		t.dropTLSConn(srv)
+		return nil, fmt.Errorf("dns-over-tls: writing query to %s: %w", srv.IPStr, err)
+	}
+
+	if _, err := io.ReadFull(pc.conn, lenBuf[:]); err != nil {
+		t.dropTLSConn(srv)
+		return nil, fmt.Errorf("dns-over-tls: reading response length from %s: %w", srv.IPStr, err)
+	}
+	respBuf := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(pc.conn, respBuf); err != nil {
+		t.dropTLSConn(srv)
+		return nil, fmt.Errorf("dns-over-tls: reading response from %s: %w", srv.IPStr, err)
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(respBuf); err != nil {
+		return nil, fmt.Errorf("dns-over-tls: unpacking response from %s: %w", srv.IPStr, err)
+	}
+	return resp, nil
+}
+
+func (t *upstreamTransports) tlsConn(srv extDNSEntry) (*pooledTLSConn, error) {
+	key := tlsConnKey(srv)
+
+	t.mu.Lock()
+	if pc, ok := t.tlsConns[key]; ok {
+		t.mu.Unlock()
+		return pc, nil
+	}
+	t.mu.Unlock()
+
+	dialer := &net.Dialer{Timeout: extIOTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(srv.IPStr, effectivePort(srv, dnsOverTLSPort)), &tls.Config{
+		ServerName: srv.ServerName,
+		MinVersion: tls.VersionTLS12,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dns-over-tls: dialing %s: %w", srv.IPStr, err)
+	}
+
+	pc := &pooledTLSConn{conn: conn}
+	t.mu.Lock()
+	t.tlsConns[key] = pc
+	t.mu.Unlock()
+	return pc, nil
+}
+
+func (t *upstreamTransports) dropTLSConn(srv extDNSEntry) {
+	key := tlsConnKey(srv)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pc, ok := t.tlsConns[key]; ok {
+		pc.conn.Close()
+		delete(t.tlsConns, key)
+	}
+}
+
+// tlsConnKey identifies the pooled connection for srv, distinguishing servers that share an IP but use different
+// ports.
+func tlsConnKey(srv extDNSEntry) string {
+	return net.JoinHostPort(srv.IPStr, effectivePort(srv, dnsOverTLSPort))
+}
+
+// exchangeHTTPS performs a DNS-over-HTTPS exchange (RFC 8484): the query is POSTed as an application/dns-message
+// body. A response carrying a Cache-Control: max-age directive is cached for that many seconds and replayed for
+// later queries asking the same question, without going back out to srv.
+func (t *upstreamTransports) exchangeHTTPS(srv extDNSEntry, query *dns.Msg) (*dns.Msg, error) {
+	cacheKey := srv.IPStr + "|" + dohCacheKey(query)
+	if resp, ok := t.dohCacheGet(cacheKey); ok {
+		resp.Id = query.Id
+		return resp, nil
+	}
+
+	client := t.httpClient(srv)
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), extIOTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.IPStr, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("dns-over-https: building request for %s: %w", srv.IPStr, err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("dns-over-https: querying %s: %w", srv.IPStr, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns-over-https: %s returned status %d", srv.IPStr, httpResp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("dns-over-https: reading response from %s: %w", srv.IPStr, err)
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("dns-over-https: unpacking response from %s: %w", srv.IPStr, err)
+	}
+	t.dohCacheStore(cacheKey, resp, httpResp.Header.Get("Cache-Control"))
+	return resp, nil
+}
+
+// dohCacheKey identifies the cache entry for query's question, which is all a DoH response varies on for a given
+// srv (dns.Msg.Id is per-exchange and intentionally ignored).
+func dohCacheKey(query *dns.Msg) string {
+	if len(query.Question) == 0 {
+		return ""
+	}
+	q := query.Question[0]
+	return fmt.Sprintf("%s|%d|%d", q.Name, q.Qtype, q.Qclass)
+}
+
+// dohCacheGet returns a copy of the cached response for key, if one exists and hasn't expired.
+func (t *upstreamTransports) dohCacheGet(key string) (*dns.Msg, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.dohCache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.resp.Copy(), true
+}
+
+// dohCacheStore caches resp under key until cacheControl's max-age elapses. Responses with no max-age directive (or
+// a non-positive one) aren't cached.
+func (t *upstreamTransports) dohCacheStore(key string, resp *dns.Msg, cacheControl string) {
+	maxAge, ok := parseMaxAge(cacheControl)
+	if !ok || maxAge <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dohCache[key] = dohCacheEntry{resp: resp.Copy(), expires: time.Now().Add(time.Duration(maxAge) * time.Second)}
+}
+
+// parseMaxAge extracts the max-age directive, in seconds, from an HTTP Cache-Control header value.
+func parseMaxAge(cacheControl string) (int, bool) {
+	for _, part := range strings.Split(cacheControl, ",") {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(part), "=")
+		if !hasValue || name != "max-age" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+func (t *upstreamTransports) httpClient(srv extDNSEntry) *http.Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if client, ok := t.httpConns[srv.IPStr]; ok {
+		return client
+	}
+
+	client := &http.Client{
+		Timeout: extIOTimeout,
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if len(srv.Bootstrap) == 0 {
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				}
+				_, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					port = "443"
+				}
+				return (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(srv.Bootstrap[0].String(), port))
+			},
+		},
+	}
+	t.httpConns[srv.IPStr] = client
+	return client
+}
@@ -0,0 +1,141 @@
+package libnetwork
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseUpstreamPlainIP(t *testing.T) {
+	entry, err := ParseUpstream("8.8.8.8", nil)
+	if err != nil {
+		t.Fatalf("ParseUpstream: %v", err)
+	}
+	if entry.IPStr != "8.8.8.8" || entry.Transport != TransportUDP || entry.Port != "" {
+		t.Errorf("ParseUpstream(%q) = %+v", "8.8.8.8", entry)
+	}
+}
+
+func TestParseUpstreamPlainIPInvalid(t *testing.T) {
+	if _, err := ParseUpstream("not-an-ip", nil); err == nil {
+		t.Fatal("expected an error for an invalid IP, got none")
+	}
+}
+
+func TestParseUpstreamWithPort(t *testing.T) {
+	for _, tc := range []struct {
+		value        string
+		wantIP       string
+		wantPort     string
+		wantTport    Transport
+		wantServer   string
+		wantErrorSub string
+	}{
+		{value: "udp://9.9.9.9@5353", wantIP: "9.9.9.9", wantPort: "5353", wantTport: TransportUDP},
+		{value: "tcp://9.9.9.9@5353", wantIP: "9.9.9.9", wantPort: "5353", wantTport: TransportTCP},
+		{value: "tls://9.9.9.9@8853#dns.example.com", wantIP: "9.9.9.9", wantPort: "8853", wantTport: TransportTLS, wantServer: "dns.example.com"},
+		{value: "tls://9.9.9.9#dns.example.com", wantIP: "9.9.9.9", wantPort: "", wantTport: TransportTLS, wantServer: "dns.example.com"},
+		{value: "tls://9.9.9.9", wantErrorSub: "server-name"},
+		{value: "udp://not-an-ip", wantErrorSub: "invalid DNS server"},
+		{value: "udp://9.9.9.9@not-a-port", wantErrorSub: "invalid port"},
+		{value: "ftp://9.9.9.9", wantErrorSub: "unsupported"},
+	} {
+		entry, err := ParseUpstream(tc.value, nil)
+		if tc.wantErrorSub != "" {
+			if err == nil {
+				t.Errorf("ParseUpstream(%q): expected an error containing %q, got none", tc.value, tc.wantErrorSub)
+			} else if !strings.Contains(err.Error(), tc.wantErrorSub) {
+				t.Errorf("ParseUpstream(%q): error %q does not contain %q", tc.value, err, tc.wantErrorSub)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseUpstream(%q): %v", tc.value, err)
+			continue
+		}
+		if entry.IPStr != tc.wantIP || entry.Port != tc.wantPort || entry.Transport != tc.wantTport || entry.ServerName != tc.wantServer {
+			t.Errorf("ParseUpstream(%q) = %+v, want IPStr=%q Port=%q Transport=%q ServerName=%q",
+				tc.value, entry, tc.wantIP, tc.wantPort, tc.wantTport, tc.wantServer)
+		}
+	}
+}
+
+func TestParseUpstreamHTTPS(t *testing.T) {
+	bootstrap := []netip.Addr{netip.MustParseAddr("8.8.8.8")}
+	entry, err := ParseUpstream("https://dns.google/dns-query", bootstrap)
+	if err != nil {
+		t.Fatalf("ParseUpstream: %v", err)
+	}
+	if entry.Transport != TransportHTTPS {
+		t.Errorf("Transport = %v, want TransportHTTPS", entry.Transport)
+	}
+	if entry.IPStr != "https://dns.google/dns-query" {
+		t.Errorf("IPStr = %q, want the DoH URL unchanged", entry.IPStr)
+	}
+	if entry.ServerName != "dns.google" {
+		t.Errorf("ServerName = %q, want %q", entry.ServerName, "dns.google")
+	}
+	if len(entry.Bootstrap) != 1 || entry.Bootstrap[0] != bootstrap[0] {
+		t.Errorf("Bootstrap = %v, want %v", entry.Bootstrap, bootstrap)
+	}
+}
+
+func TestEffectivePort(t *testing.T) {
+	if got := effectivePort(extDNSEntry{Port: "5353"}, "53"); got != "5353" {
+		t.Errorf("effectivePort with explicit port = %q, want %q", got, "5353")
+	}
+	if got := effectivePort(extDNSEntry{}, "53"); got != "53" {
+		t.Errorf("effectivePort with no explicit port = %q, want default %q", got, "53")
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	for _, tc := range []struct {
+		cacheControl string
+		wantMaxAge   int
+		wantOK       bool
+	}{
+		{cacheControl: "max-age=300", wantMaxAge: 300, wantOK: true},
+		{cacheControl: "private, max-age=60", wantMaxAge: 60, wantOK: true},
+		{cacheControl: "no-store", wantOK: false},
+		{cacheControl: "", wantOK: false},
+		{cacheControl: "max-age=not-a-number", wantOK: false},
+	} {
+		gotMaxAge, gotOK := parseMaxAge(tc.cacheControl)
+		if gotOK != tc.wantOK || (gotOK && gotMaxAge != tc.wantMaxAge) {
+			t.Errorf("parseMaxAge(%q) = (%d, %t), want (%d, %t)", tc.cacheControl, gotMaxAge, gotOK, tc.wantMaxAge, tc.wantOK)
+		}
+	}
+}
+
+func TestDoHCacheRoundTrip(t *testing.T) {
+	transports := newUpstreamTransports()
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	query.Id = 1
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+
+	key := "9.9.9.9|" + dohCacheKey(query)
+	if _, ok := transports.dohCacheGet(key); ok {
+		t.Fatal("dohCacheGet on an empty cache returned a hit")
+	}
+
+	transports.dohCacheStore(key, resp, "max-age=300")
+	cached, ok := transports.dohCacheGet(key)
+	if !ok {
+		t.Fatal("dohCacheGet after a store with max-age returned no hit")
+	}
+	if len(cached.Answer) != len(resp.Answer) {
+		t.Errorf("cached response diverges from the stored one: %+v vs %+v", cached, resp)
+	}
+
+	transports.dohCacheStore(key+"-nocache", resp, "no-store")
+	if _, ok := transports.dohCacheGet(key + "-nocache"); ok {
+		t.Error("dohCacheGet returned a hit for a response with no max-age")
+	}
+}
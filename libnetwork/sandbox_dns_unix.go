@@ -6,15 +6,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"net"
+	"net/netip"
 	"os"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/containerd/containerd/log"
 	"github.com/docker/docker/libnetwork/etchosts"
+	"github.com/docker/docker/libnetwork/internal/atomicfile"
 	"github.com/docker/docker/libnetwork/resolvconf"
 	"github.com/docker/docker/libnetwork/types"
 )
@@ -34,6 +34,8 @@ func (sb *Sandbox) startResolver(restore bool) {
 		// an internal network. This way, it's the driver responsibility to make sure `connect` syscall fails fast when
 		// no external connectivity is available (eg. by not setting a default gateway).
 		sb.resolver = NewResolver(resolverIPSandbox, true, sb)
+		sb.resolver.SetObservability(sb.controller.dnsStats, sb.controller.dnsQueryLog)
+		sb.resolver.SetStrictEncryptedDNS(sb.controller.cfg.Daemon.StrictEncryptedDNS)
 		defer func() {
 			if err != nil {
 				sb.resolver = nil
@@ -175,27 +177,45 @@ func (sb *Sandbox) restorePath() {
 	}
 }
 
-func (sb *Sandbox) setExternalResolvers(content []byte, addrType int, checkLoopback bool) {
-	servers := resolvconf.GetNameservers(content, addrType)
-	for _, ip := range servers {
-		hostLoopback := false
-		if checkLoopback && isIPv4Loopback(ip) {
-			hostLoopback = true
-		}
+// setExternalResolvers records the nameservers of the given family from rc as the sandbox's external resolvers,
+// so the embedded resolver (or the container itself, if it isn't enabled) knows where to forward queries it can't
+// answer itself. checkLoopback marks entries pointing at the host's own loopback resolver (127.0.0.0/8), which the
+// docker embedded DNS server treats specially since it refers to the host namespace, not the container's.
+func (sb *Sandbox) setExternalResolvers(rc *resolvconf.ResolvConf, family resolvconf.IPFamily, checkLoopback bool) {
+	for _, ns := range rc.Nameservers(family) {
 		sb.extDNS = append(sb.extDNS, extDNSEntry{
-			IPStr:        ip,
-			HostLoopback: hostLoopback,
+			IPStr:        ns.String(),
+			HostLoopback: checkLoopback && ns.Is4() && ns.As4()[0] == 127,
 		})
 	}
 }
 
-// isIPv4Loopback checks if the given IP address is an IPv4 loopback address.
-// It's based on the logic in Go's net.IP.IsLoopback(), but only the IPv4 part:
-// https://github.com/golang/go/blob/go1.16.6/src/net/ip.go#L120-L126
-func isIPv4Loopback(ipAddress string) bool {
-	if ip := net.ParseIP(ipAddress); ip != nil {
-		if ip4 := ip.To4(); ip4 != nil {
-			return ip4[0] == 127
+// endpointAndNetworkDNS returns the endpoint-level and network-level DNS override layers for this sandbox, for use
+// with resolvconf.Merge: every joined endpoint's own DNS overrides, and separately its network's, each folded
+// together in join order so a container attached to several networks picks up each one's search domain alongside
+// the others.
+func (sb *Sandbox) endpointAndNetworkDNS() (endpoint, network *resolvconf.ResolvConf) {
+	var epLayers, netLayers []*resolvconf.ResolvConf
+	for _, ep := range sb.endpoints {
+		epLayers = append(epLayers, ep.resolvConf())
+		netLayers = append(netLayers, ep.getNetwork().resolvConf())
+	}
+	return resolvconf.Merge(epLayers...), resolvconf.Merge(netLayers...)
+}
+
+// hasDNSOverride reports whether this sandbox should be insulated from host resolv.conf changes: either because it
+// was given explicit sandbox-level --dns/--dns-search/--dns-option flags, or because one of its endpoints or
+// networks carries a DNS override. reconcileHostDNS and updateDNS use this, instead of checking the sandbox-level
+// flags alone, so a network/endpoint override set up by setupDNS isn't silently discarded the first time the host's
+// resolv.conf changes.
+func (sb *Sandbox) hasDNSOverride() bool {
+	if len(sb.config.dnsList) > 0 || len(sb.config.dnsSearchList) > 0 || len(sb.config.dnsOptionsList) > 0 {
+		return true
+	}
+	epRC, netRC := sb.endpointAndNetworkDNS()
+	for _, rc := range []*resolvconf.ResolvConf{epRC, netRC} {
+		if len(rc.Nameservers(resolvconf.IP)) > 0 || len(rc.SearchDomains()) > 0 || len(rc.Options()) > 0 {
+			return true
 		}
 	}
 	return false
@@ -235,62 +255,47 @@ func (sb *Sandbox) setupDNS() error {
 		// fallback if not specified
 		originResolvConfPath = resolvconf.Path()
 	}
-	currRC, err := os.ReadFile(originResolvConfPath)
+	hostRC, err := resolvconf.Load(originResolvConfPath)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return err
 		}
 		// No /etc/resolv.conf found: we'll use the default resolvers (Google's Public DNS).
 		log.G(context.TODO()).WithField("path", originResolvConfPath).Infof("no resolv.conf found, falling back to defaults")
+		hostRC = &resolvconf.ResolvConf{}
 	}
 
-	var newRC *resolvconf.File
-	if len(sb.config.dnsList) > 0 || len(sb.config.dnsSearchList) > 0 || len(sb.config.dnsOptionsList) > 0 {
-		var (
-			dnsList        = sb.config.dnsList
-			dnsSearchList  = sb.config.dnsSearchList
-			dnsOptionsList = sb.config.dnsOptionsList
-		)
-		if len(sb.config.dnsList) == 0 {
-			dnsList = resolvconf.GetNameservers(currRC, resolvconf.IP)
-		}
-		if len(sb.config.dnsSearchList) == 0 {
-			dnsSearchList = resolvconf.GetSearchDomains(currRC)
-		}
-		if len(sb.config.dnsOptionsList) == 0 {
-			dnsOptionsList = resolvconf.GetOptions(currRC)
-		}
-		newRC, err = resolvconf.Build(sb.config.resolvConfPath, dnsList, dnsSearchList, dnsOptionsList)
-		if err != nil {
-			return err
-		}
-		// After building the resolv.conf from the user config save the
-		// external resolvers in the sandbox. Note that --dns 127.0.0.x
-		// config refers to the loopback in the container namespace
-		sb.setExternalResolvers(newRC.Content, resolvconf.IPv4, len(sb.config.dnsList) == 0)
-	} else {
-		// If the host resolv.conf file has 127.0.0.x container should
-		// use the host resolver for queries. This is supported by the
-		// docker embedded DNS server. Hence save the external resolvers
-		// before filtering it out.
-		sb.setExternalResolvers(currRC, resolvconf.IPv4, true)
-
-		// Replace any localhost/127.* (at this point we have no info about ipv6, pass it as true)
-		newRC, err = resolvconf.FilterResolvDNS(currRC, true)
-		if err != nil {
-			return err
-		}
-		// No contention on container resolv.conf file at sandbox creation
-		err = os.WriteFile(sb.config.resolvConfPath, newRC.Content, filePerm)
-		if err != nil {
-			return types.InternalErrorf("failed to write unhaltered resolv.conf file content when setting up dns for sandbox %s: %v", sb.ID(), err)
-		}
+	// Entries naming an encrypted upstream transport (tls://, https://, ...) aren't valid resolv.conf nameserver
+	// lines; they're only usable by the embedded resolver, so they bypass the container's resolv.conf and go
+	// straight to sb.extDNS below.
+	plain, encrypted := splitEncryptedUpstreams(sb.config.dnsList)
+	sb.extDNS = append(sb.extDNS, encrypted...)
+
+	cliRC := &resolvconf.ResolvConf{}
+	cliRC.AddNameservers(parseAddrs(plain))
+	cliRC.SetSearchDomains(sb.config.dnsSearchList)
+	cliRC.SetOptions(toOptions(sb.config.dnsOptionsList))
+
+	// Merge in priority order: endpoint-level overrides, then the sandbox's own CLI flags, then network-level
+	// overrides, then the host's resolv.conf. Search domains are concatenated across every layer (deduped) so a
+	// container joined to several networks picks up each one's search domain.
+	epRC, netRC := sb.endpointAndNetworkDNS()
+	rc := resolvconf.Merge(epRC, cliRC, netRC, hostRC)
+
+	// explicitNS is true once nameservers came from something other than the host's own resolv.conf: either the
+	// sandbox's CLI flags, or an endpoint/network override. We only need to special-case the host's resolv.conf
+	// itself, since its entries may refer to the host's own loopback resolver and/or still need legacy-resolver
+	// transformation; explicit overrides are used as configured.
+	explicitNS := len(sb.config.dnsList) > 0 || len(epRC.Nameservers(resolvconf.IP)) > 0 || len(netRC.Nameservers(resolvconf.IP)) > 0
+	sb.setExternalResolvers(rc, resolvconf.IPv4, !explicitNS)
+	if !explicitNS {
+		// Replace any localhost/127.* (at this point we have no info about ipv6, so keep it).
+		rc.TransformForLegacyResolver(true)
 	}
 
-	// Write hash
-	err = os.WriteFile(sb.config.resolvConfHashFile, newRC.Hash, filePerm)
-	if err != nil {
-		return types.InternalErrorf("failed to write resolv.conf hash file when setting up dns for sandbox %s: %v", sb.ID(), err)
+	// No contention on container resolv.conf file at sandbox creation
+	if err := rc.Write(sb.config.resolvConfPath, true); err != nil {
+		return types.InternalErrorf("failed to write resolv.conf file when setting up dns for sandbox %s: %v", sb.ID(), err)
 	}
 
 	return nil
@@ -302,58 +307,71 @@ func (sb *Sandbox) updateDNS(ipv6Enabled bool) error {
 		return nil
 	}
 
-	if len(sb.config.dnsList) > 0 || len(sb.config.dnsSearchList) > 0 || len(sb.config.dnsOptionsList) > 0 {
+	if sb.hasDNSOverride() {
 		return nil
 	}
 
-	var currHash []byte
-	currRC, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+	content, err := os.ReadFile(sb.config.resolvConfPath)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-	} else {
-		currHash, err = os.ReadFile(sb.config.resolvConfHashFile)
-		if err != nil && !os.IsNotExist(err) {
-			return err
+		if os.IsNotExist(err) {
+			return nil
 		}
+		return err
+	}
+
+	currHash, err := os.ReadFile(sb.config.resolvConfHashFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
 	}
 
-	if len(currHash) > 0 && !bytes.Equal(currHash, currRC.Hash) {
+	if len(currHash) > 0 && !bytes.Equal(currHash, resolvconf.Hash(content)) {
 		// Seems the user has changed the container resolv.conf since the last time
 		// we checked so return without doing anything.
 		// log.G(ctx).Infof("Skipping update of resolv.conf file with ipv6Enabled: %t because file was touched by user", ipv6Enabled)
 		return nil
 	}
 
-	// replace any localhost/127.* and remove IPv6 nameservers if IPv6 disabled.
-	newRC, err := resolvconf.FilterResolvDNS(currRC.Content, ipv6Enabled)
-	if err != nil {
-		return err
-	}
-	err = os.WriteFile(sb.config.resolvConfPath, newRC.Content, filePerm)
+	rc, err := resolvconf.Parse(content)
 	if err != nil {
 		return err
 	}
+	// replace any localhost/127.* and remove IPv6 nameservers if IPv6 disabled.
+	rc.TransformForLegacyResolver(ipv6Enabled)
 
-	// write the new hash in a temp file and rename it to make the update atomic
-	dir := path.Dir(sb.config.resolvConfPath)
-	tmpHashFile, err := os.CreateTemp(dir, "hash")
-	if err != nil {
-		return err
+	return rc.Write(sb.config.resolvConfPath, true)
+}
+
+// reconcileHostDNS re-reads the host's resolv.conf and, unless this sandbox has a DNS override of its own (explicit
+// --dns/--dns-search/--dns-option configuration, or an endpoint/network-level override — see hasDNSOverride),
+// recomputes its external resolvers and hands them to the embedded resolver, if one is running. It then runs
+// updateDNS, with the same hash-file check, so a container that has edited its own resolv.conf keeps its changes;
+// updateDNS re-filters the nameservers already in the container's resolv.conf and does not copy the host's new
+// nameservers into it. It's invoked by the controller's host resolv.conf watcher.
+func (sb *Sandbox) reconcileHostDNS() error {
+	if sb.config.useDefaultSandBox {
+		return nil
 	}
-	if err = tmpHashFile.Chmod(filePerm); err != nil {
-		tmpHashFile.Close()
-		return err
+	if sb.hasDNSOverride() {
+		return nil
 	}
-	_, err = tmpHashFile.Write(newRC.Hash)
-	if err1 := tmpHashFile.Close(); err == nil {
-		err = err1
+
+	originResolvConfPath := sb.config.originResolvConfPath
+	if originResolvConfPath == "" {
+		originResolvConfPath = resolvconf.Path()
 	}
+	hostRC, err := resolvconf.Load(originResolvConfPath)
 	if err != nil {
 		return err
 	}
-	return os.Rename(tmpHashFile.Name(), sb.config.resolvConfHashFile)
+
+	sb.extDNS = nil
+	sb.setExternalResolvers(hostRC, resolvconf.IPv4, true)
+	if sb.resolver != nil {
+		sb.resolver.SetExtServers(sb.extDNS)
+	}
+
+	// At this point we have no more information about IPv6 than setupDNS had, so keep the same default.
+	return sb.updateDNS(true)
 }
 
 // Embedded DNS server has to be enabled for this sandbox. Rebuild the container's
@@ -361,7 +379,11 @@ func (sb *Sandbox) updateDNS(ipv6Enabled bool) error {
 // - Add only the embedded server's IP to container's resolv.conf
 // - If the embedded server needs any resolv.conf options add it to the current list
 func (sb *Sandbox) rebuildDNS() error {
-	currRC, err := os.ReadFile(sb.config.resolvConfPath)
+	content, err := os.ReadFile(sb.config.resolvConfPath)
+	if err != nil {
+		return err
+	}
+	rc, err := resolvconf.Parse(content)
 	if err != nil {
 		return err
 	}
@@ -370,48 +392,96 @@ func (sb *Sandbox) rebuildDNS() error {
 	// remember the user's config so that unqualified names not in the docker
 	// domain can be dropped.
 	resOptions := sb.resolver.ResolverOptions()
-	dnsOptionsList := resolvconf.GetOptions(currRC)
+	options := rc.Options()
 
 dnsOpt:
 	for _, resOpt := range resOptions {
-		if strings.Contains(resOpt, "ndots") {
-			for _, option := range dnsOptionsList {
-				if strings.Contains(option, "ndots") {
-					parts := strings.Split(option, ":")
-					if len(parts) != 2 {
-						return fmt.Errorf("invalid ndots option %v", option)
-					}
-					if num, err := strconv.Atoi(parts[1]); err != nil {
-						return fmt.Errorf("invalid number for ndots option: %v", parts[1])
-					} else if num >= 0 {
-						// if the user sets ndots, use the user setting
-						sb.ndotsSet = true
-						break dnsOpt
-					} else {
-						return fmt.Errorf("invalid number for ndots option: %v", num)
-					}
-				}
+		if !strings.Contains(resOpt, "ndots") {
+			continue
+		}
+		for _, opt := range options {
+			if opt.Name() != "ndots" {
+				continue
+			}
+			value, ok := opt.Value()
+			if !ok {
+				return fmt.Errorf("invalid ndots option %v", opt)
+			}
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number for ndots option: %v", value)
+			}
+			if num < 0 {
+				return fmt.Errorf("invalid number for ndots option: %v", num)
 			}
+			// if the user sets ndots, use the user setting
+			sb.ndotsSet = true
+			break dnsOpt
 		}
 	}
 
 	if !sb.ndotsSet {
 		// if the user did not set the ndots, set it to 0 to prioritize the service name resolution
 		// Ref: https://linux.die.net/man/5/resolv.conf
-		dnsOptionsList = append(dnsOptionsList, resOptions...)
+		options = append(options, toOptions(resOptions)...)
 	}
 	if len(sb.extDNS) == 0 {
-		sb.setExternalResolvers(currRC, resolvconf.IPv4, false)
+		sb.setExternalResolvers(rc, resolvconf.IPv4, false)
 	}
 
-	var (
-		// external v6 DNS servers have to be listed in resolv.conf
-		dnsList       = append([]string{sb.resolver.NameServer()}, resolvconf.GetNameservers(currRC, resolvconf.IPv6)...)
-		dnsSearchList = resolvconf.GetSearchDomains(currRC)
-	)
+	dnsServer, err := netip.ParseAddr(sb.resolver.NameServer())
+	if err != nil {
+		return err
+	}
 
-	_, err = resolvconf.Build(sb.config.resolvConfPath, dnsList, dnsSearchList, dnsOptionsList)
-	return err
+	// external v6 DNS servers have to be listed in resolv.conf
+	out := &resolvconf.ResolvConf{}
+	out.AddNameservers(append([]netip.Addr{dnsServer}, rc.Nameservers(resolvconf.IPv6)...))
+	out.SetSearchDomains(rc.SearchDomains())
+	out.SetOptions(options)
+
+	// No hash file here: rebuildDNS always runs at sandbox/resolver startup, so there's nothing to
+	// detect a user edit against yet.
+	return out.Write(sb.config.resolvConfPath, false)
+}
+
+// splitEncryptedUpstreams separates --dns values into plain IP addresses and entries naming an encrypted
+// transport (tls://, https://, ...), parsing the latter into extDNSEntry values ready to feed to sb.extDNS.
+func splitEncryptedUpstreams(dnsList []string) (plain []string, encrypted []extDNSEntry) {
+	for _, s := range dnsList {
+		if !strings.Contains(s, "://") {
+			plain = append(plain, s)
+			continue
+		}
+		entry, err := ParseUpstream(s, nil)
+		if err != nil {
+			log.G(context.TODO()).WithError(err).WithField("dns", s).Warn("libnetwork: ignoring invalid DNS server")
+			continue
+		}
+		encrypted = append(encrypted, entry)
+	}
+	return plain, encrypted
+}
+
+// parseAddrs converts a list of user-supplied DNS server strings into addresses, silently dropping any that
+// don't parse since they were already validated by the CLI/API layer before reaching the sandbox.
+func parseAddrs(addrs []string) []netip.Addr {
+	out := make([]netip.Addr, 0, len(addrs))
+	for _, s := range addrs {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// toOptions converts raw "key" / "key:value" strings into resolvconf.Option values.
+func toOptions(opts []string) []resolvconf.Option {
+	out := make([]resolvconf.Option, 0, len(opts))
+	for _, o := range opts {
+		out = append(out, resolvconf.Option(o))
+	}
+	return out
 }
 
 func createBasePath(dir string) error {
@@ -440,5 +510,5 @@ func copyFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(dst, sBytes, filePerm)
+	return atomicfile.WriteFile(dst, sBytes, filePerm)
 }